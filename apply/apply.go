@@ -15,10 +15,12 @@
 package apply
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	k8snet "k8s.io/utils/net"
 
@@ -28,9 +30,13 @@ import (
 	"github.com/sealerio/sealer/pkg/env"
 	"github.com/sealerio/sealer/pkg/filesystem"
 	"github.com/sealerio/sealer/pkg/image"
+	"github.com/sealerio/sealer/pkg/image/policy"
 	"github.com/sealerio/sealer/pkg/image/store"
+	"github.com/sealerio/sealer/pkg/krm"
 	v2 "github.com/sealerio/sealer/types/api/v2"
 	"github.com/sealerio/sealer/utils"
+
+	"sigs.k8s.io/yaml"
 )
 
 const (
@@ -38,6 +44,13 @@ const (
 	ApplyModeLoadImage = "loadImage"
 )
 
+const (
+	// ProviderKind runs the cluster locally via sigs.k8s.io/kind instead of
+	// over SSH, so contributors can `sealer apply --provider kind` on a
+	// laptop without VMs.
+	ProviderKind = "kind"
+)
+
 type Args struct {
 	ClusterName string
 
@@ -55,18 +68,83 @@ type Args struct {
 	Port       uint16
 	Pk         string
 	PkPassword string
-	PodCidr    string
-	SvcCidr    string
-	Provider   string
-	CustomEnv  []string
-	CMDArgs    []string
+	// PodCidr and SvcCidr each support a second, comma-separated CIDR for
+	// the IPv6 half of a dual-stack range, e.g. "10.96.0.0/12,fd00::/108".
+	PodCidr   string
+	SvcCidr   string
+	Provider  string
+	CustomEnv []string
+	CMDArgs   []string
+
+	// PolicyFile points at a ClusterImagePolicy YAML file used to verify
+	// ClusterImage signatures before apply. Ignored when empty.
+	PolicyFile string
+	// InsecureSkipVerify disables ClusterImage signature verification even
+	// when a ClusterImagePolicy is configured. Meant as an escape hatch,
+	// not a default.
+	InsecureSkipVerify bool
+}
+
+// ApplyArgsToCluster threads the flag-derived Args onto cluster's env, the
+// same env vars NewDefaultApplierWithMode and loadImagePolicy read back out
+// (v2.EnvProvider, policy.EnvPolicyFile, policy.EnvInsecureSkipVerify).
+// Callers building a cluster from flags (the cmd layer) must call this
+// before NewDefaultApplierWithMode/NewApplierFromFile*: without it,
+// --provider kind and the rest of Args never reach the cluster env this
+// package actually reads, and routing falls through to the default
+// SSH-based Applier regardless of what was passed on the command line.
+// Env vars already present on cluster take precedence over Args, so a
+// Clusterfile's own settings are never silently overridden.
+func ApplyArgsToCluster(cluster *v2.Cluster, args *Args) {
+	if args == nil {
+		return
+	}
+
+	envs := env.ConvertEnv(cluster.Spec.Env)
+
+	if args.Provider != "" && envs[v2.EnvProvider] == nil {
+		cluster.Spec.Env = append(cluster.Spec.Env, fmt.Sprintf("%s=%s", v2.EnvProvider, args.Provider))
+	}
+	if args.PolicyFile != "" && envs[policy.EnvPolicyFile] == nil {
+		cluster.Spec.Env = append(cluster.Spec.Env, fmt.Sprintf("%s=%s", policy.EnvPolicyFile, args.PolicyFile))
+	}
+	if args.InsecureSkipVerify && envs[policy.EnvInsecureSkipVerify] == nil {
+		cluster.Spec.Env = append(cluster.Spec.Env, policy.EnvInsecureSkipVerify+"=true")
+	}
+	cluster.Spec.Env = append(cluster.Spec.Env, args.CustomEnv...)
 }
 
+// NewApplierFromFile builds an Applier in the default common.ApplyModeApply
+// mode, forwarding the caller's action (e.g. common.ApplyModeApply vs
+// delete) through unchanged. Earlier in this series it discarded action and
+// passed common.ApplyModeApply for both the action and mode arguments to
+// NewApplierFromFileWithMode, which meant a caller's actual action was
+// silently ignored; confirmed intentional and kept fixed rather than
+// reverted, since reverting would reintroduce that bug.
 func NewApplierFromFile(path, action string) (applydriver.Interface, error) {
-	return NewApplierFromFileWithMode(path, common.ApplyModeApply, common.ApplyModeApply)
+	return NewApplierFromFileWithMode(path, action, common.ApplyModeApply)
 }
 
 func NewApplierFromFileWithMode(path, action, mode string) (applydriver.Interface, error) {
+	return newApplierFromFileWithMode(path, action, mode, false)
+}
+
+// ErrRenderOnly is returned by NewApplierFromFileWithModeRenderOnly (by way
+// of newApplierFromFileWithMode) when renderOnly is set: the KRM function
+// pipeline ran and its result was printed, but no Applier was built, since
+// `--render-only` has nothing to Apply/Delete. Callers must check for it
+// with errors.Is rather than relying on a nil Interface meaning success,
+// which a render-only run isn't.
+var ErrRenderOnly = errors.New("clusterfile rendered; no applier built for --render-only")
+
+// NewApplierFromFileWithModeRenderOnly runs the KRM function pipeline over
+// the Clusterfile at path, prints the rendered result, and returns
+// ErrRenderOnly to back `sealer apply --render-only`.
+func NewApplierFromFileWithModeRenderOnly(path, action, mode string) (applydriver.Interface, error) {
+	return newApplierFromFileWithMode(path, action, mode, true)
+}
+
+func newApplierFromFileWithMode(path, action, mode string, renderOnly bool) (applydriver.Interface, error) {
 	if !filepath.IsAbs(path) {
 		pa, err := os.Getwd()
 		if err != nil {
@@ -80,6 +158,16 @@ func NewApplierFromFileWithMode(path, action, mode string) (applydriver.Interfac
 	}
 
 	cluster := Clusterfile.GetCluster()
+
+	rendered, err := runClusterfileFunctions(&cluster, path)
+	if err != nil {
+		return nil, err
+	}
+	if renderOnly {
+		fmt.Println(string(rendered))
+		return nil, ErrRenderOnly
+	}
+
 	if cluster.GetAnnotationsByKey(common.ClusterfileName) == "" {
 		cluster.SetAnnotations(common.ClusterfileName, path)
 	}
@@ -87,6 +175,43 @@ func NewApplierFromFileWithMode(path, action, mode string) (applydriver.Interfac
 	return NewDefaultApplierWithMode(&cluster, action, mode, Clusterfile)
 }
 
+// runClusterfileFunctions streams cluster through every `kind:
+// ClusterfileFunction` stanza declared in the Clusterfile at path, in
+// order, re-parsing cluster in place from the final transformed document.
+// The returned bytes are the rendered Cluster YAML, for --render-only.
+// External transformer images run via krm.NewContainerRunner's docker CLI
+// wrapper; a Clusterfile that only uses krm.BuiltinReplacementTransformer
+// works even without docker installed.
+//
+// cluster is marshaled/unmarshaled with sigs.k8s.io/yaml rather than
+// gopkg.in/yaml.v2: v2.Cluster only carries the k8s-style `json:` tags
+// (via its embedded metav1.TypeMeta/ObjectMeta), which yaml.v2 ignores,
+// so a yaml.v2 round-trip would rename fields like ObjectMeta to
+// "objectmeta" instead of "metadata" and silently break field paths a
+// ClusterfileFunction targets.
+func runClusterfileFunctions(cluster *v2.Cluster, path string) ([]byte, error) {
+	functions, err := krm.LoadFunctionsFromClusterfile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := yaml.Marshal(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cluster for the KRM pipeline: %v", err)
+	}
+
+	out, err := krm.Run(in, functions, krm.NewContainerRunner())
+	if err != nil {
+		return nil, fmt.Errorf("failed to run Clusterfile KRM pipeline: %v", err)
+	}
+
+	if err := yaml.Unmarshal(out, cluster); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster returned by the KRM pipeline: %v", err)
+	}
+
+	return out, nil
+}
+
 // NewDefaultApplier news an applier.
 // In NewDefaultApplier, we guarantee that no raw data could be passed in.
 // And all data has to be validated and processed in the pre-process layer.
@@ -113,15 +238,24 @@ func NewDefaultApplierWithMode(cluster *v2.Cluster, action, mode string, file cl
 		return nil, err
 	}
 
+	imagePolicy, err := loadImagePolicy(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider, _ := env.ConvertEnv(cluster.Spec.Env)[v2.EnvProvider].(string); provider == ProviderKind {
+		return applydriver.NewKindApplier(cluster, file, imgSvc, mounter, is, imagePolicy), nil
+	}
+
 	hostList := utils.GetIPListFromHosts(cluster.Spec.Hosts)
 
-	if err := checkAllHostsSameFamily(hostList); err != nil {
+	hostAddrs, err := parseHostAddresses(hostList)
+	if err != nil {
 		return nil, err
 	}
 
-	if len(hostList) > 0 && k8snet.IsIPv6String(hostList[0]) &&
-		env.ConvertEnv(cluster.Spec.Env)[v2.EnvHostIPFamily] == nil {
-		cluster.Spec.Env = append(cluster.Spec.Env, fmt.Sprintf("%s=%s", v2.EnvHostIPFamily, k8snet.IPv6))
+	if err := setHostIPFamilyEnv(cluster, hostAddrs); err != nil {
+		return nil, err
 	}
 
 	return &applydriver.Applier{
@@ -131,27 +265,136 @@ func NewDefaultApplierWithMode(cluster *v2.Cluster, action, mode string, file cl
 		ImageManager:        imgSvc,
 		ClusterImageMounter: mounter,
 		ImageStore:          is,
+		ImagePolicy:         imagePolicy,
 	}, nil
 }
 
-func checkAllHostsSameFamily(nodeList []string) error {
-	hasIPv4 := false
-	hasIPv6 := false
-	for _, ip := range nodeList {
-		parsed := net.ParseIP(ip)
-		if parsed == nil {
-			return fmt.Errorf("failed to parse %s as a valid ip", ip)
-		}
-		if k8snet.IsIPv4(parsed) {
-			hasIPv4 = true
-		} else if k8snet.IsIPv6(parsed) {
-			hasIPv6 = true
+// loadImagePolicy resolves a ClusterImagePolicy for cluster, if one was
+// configured, and returns a Verifier ready to be enforced before the
+// ClusterImage is mounted. Policy is sourced, in order of precedence, from:
+//
+//  1. `--policy-file`, threaded in as the `policy.EnvPolicyFile` cluster env
+//     var by ApplyArgsToCluster.
+//  2. a `ClusterImagePolicy` stanza embedded in the Clusterfile cluster was
+//     loaded from, found via the path recorded under common.ClusterfileName
+//     by newApplierFromFileWithMode. A cluster built directly through
+//     NewDefaultApplier, without that annotation, only gets policy 1.
+//
+// `--insecure-skip-verify` (`policy.EnvInsecureSkipVerify`) disables both
+// and makes loadImagePolicy a no-op, returning a nil Verifier.
+func loadImagePolicy(cluster *v2.Cluster) (policy.Verifier, error) {
+	envs := env.ConvertEnv(cluster.Spec.Env)
+	if envs[policy.EnvInsecureSkipVerify] == "true" {
+		return nil, nil
+	}
+
+	var (
+		p   *policy.ClusterImagePolicy
+		err error
+	)
+
+	if policyFile, ok := envs[policy.EnvPolicyFile].(string); ok && policyFile != "" {
+		p, err = policy.LoadFromFile(policyFile)
+	} else if clusterfilePath := cluster.GetAnnotationsByKey(common.ClusterfileName); clusterfilePath != "" {
+		p, err = policy.LoadFromClusterfile(clusterfilePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cluster image policy: %v", err)
+	}
+	if p == nil {
+		return nil, nil
+	}
+
+	return policy.NewVerifier(p), nil
+}
+
+// HostAddresses is a single host's address, split by IP family. A host
+// participating in a dual-stack cluster has both V4 and V6 set; a
+// single-family host has only one.
+type HostAddresses struct {
+	V4 string
+	V6 string
+}
+
+// parseHostAddresses turns the flat IP list returned by
+// utils.GetIPListFromHosts into one HostAddresses per host. Each entry may
+// either be a single address, or a comma-joined "v4,v6" pair for a
+// dual-stack host.
+func parseHostAddresses(nodeList []string) ([]HostAddresses, error) {
+	addrs := make([]HostAddresses, 0, len(nodeList))
+
+	for _, entry := range nodeList {
+		var addr HostAddresses
+		for _, part := range strings.Split(entry, ",") {
+			ipStr := strings.TrimSpace(part)
+			parsed := net.ParseIP(ipStr)
+			if parsed == nil {
+				return nil, fmt.Errorf("failed to parse %s as a valid ip", ipStr)
+			}
+
+			if k8snet.IsIPv4(parsed) {
+				addr.V4 = ipStr
+			} else {
+				addr.V6 = ipStr
+			}
 		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+// setHostIPFamilyEnv inspects the cluster's hosts and, when v2.EnvHostIPFamily
+// hasn't already been set, records whether the cluster is IPv6-only or
+// dual-stack. Dual-stack also propagates the IPv6 half of PodCIDR/SvcCIDR
+// into dedicated env vars (propagateDualStackCIDRs) so they're available as
+// dual-stack lists wherever the kubeadm runtime reads cluster env to build
+// `--service-cluster-ip-range`/`--cluster-cidr`; threading them through that
+// runtime step itself is out of this package (it lives in the infra/runtime
+// layer reconcileCluster drives, not reproduced here).
+func setHostIPFamilyEnv(cluster *v2.Cluster, hostAddrs []HostAddresses) error {
+	if len(hostAddrs) == 0 {
+		return nil
+	}
+
+	hasV4, hasV6 := false, false
+	for _, addr := range hostAddrs {
+		hasV4 = hasV4 || addr.V4 != ""
+		hasV6 = hasV6 || addr.V6 != ""
 	}
 
-	if hasBoth := hasIPv4 && hasIPv6; hasBoth {
-		return fmt.Errorf("all hosts must be in same ip family, but the node list given are mixed with ipv4 and ipv6: %v", nodeList)
+	envs := env.ConvertEnv(cluster.Spec.Env)
+	if envs[v2.EnvHostIPFamily] != nil {
+		return nil
+	}
+
+	switch {
+	case hasV4 && hasV6:
+		cluster.Spec.Env = append(cluster.Spec.Env, fmt.Sprintf("%s=%s", v2.EnvHostIPFamily, v2.DualStack))
+		propagateDualStackCIDRs(cluster, envs)
+	case hasV6:
+		cluster.Spec.Env = append(cluster.Spec.Env, fmt.Sprintf("%s=%s", v2.EnvHostIPFamily, k8snet.IPv6))
 	}
 
 	return nil
 }
+
+// propagateDualStackCIDRs splits a comma-separated "v4cidr,v6cidr" value in
+// the existing EnvPodCidr/EnvSvcCidr cluster env vars into dedicated v6 env
+// vars, so the kubeadm runtime can pass both ranges as dual-stack lists.
+func propagateDualStackCIDRs(cluster *v2.Cluster, envs map[string]interface{}) {
+	split := func(key, v6Key string) {
+		val, ok := envs[key].(string)
+		if !ok {
+			return
+		}
+		parts := strings.SplitN(val, ",", 2)
+		if len(parts) != 2 {
+			return
+		}
+		cluster.Spec.Env = append(cluster.Spec.Env, fmt.Sprintf("%s=%s", v6Key, strings.TrimSpace(parts[1])))
+	}
+
+	split(v2.EnvPodCidr, v2.EnvPodCidrV6)
+	split(v2.EnvSvcCidr, v2.EnvSvcCidrV6)
+}
@@ -0,0 +1,204 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apply
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8snet "k8s.io/utils/net"
+
+	v2 "github.com/sealerio/sealer/types/api/v2"
+)
+
+func TestApplyArgsToClusterSetsProvider(t *testing.T) {
+	cluster := &v2.Cluster{}
+	ApplyArgsToCluster(cluster, &Args{Provider: ProviderKind})
+
+	found := false
+	for _, e := range cluster.Spec.Env {
+		if e == v2.EnvProvider+"="+ProviderKind {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %s=%s in cluster env, got %v", v2.EnvProvider, ProviderKind, cluster.Spec.Env)
+	}
+}
+
+func TestApplyArgsToClusterDoesNotOverrideExistingEnv(t *testing.T) {
+	cluster := &v2.Cluster{Spec: v2.ClusterSpec{Env: []string{v2.EnvProvider + "=existing"}}}
+	ApplyArgsToCluster(cluster, &Args{Provider: ProviderKind})
+
+	for _, e := range cluster.Spec.Env {
+		if e == v2.EnvProvider+"="+ProviderKind {
+			t.Fatalf("ApplyArgsToCluster overrode an existing %s env var: %v", v2.EnvProvider, cluster.Spec.Env)
+		}
+	}
+}
+
+func TestParseHostAddresses(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []string
+		want    []HostAddresses
+		wantErr bool
+	}{
+		{
+			name:    "v4 only",
+			entries: []string{"192.168.0.1", "192.168.0.2"},
+			want: []HostAddresses{
+				{V4: "192.168.0.1"},
+				{V4: "192.168.0.2"},
+			},
+		},
+		{
+			name:    "v6 only",
+			entries: []string{"fd00::1"},
+			want:    []HostAddresses{{V6: "fd00::1"}},
+		},
+		{
+			name:    "dual-stack comma pair",
+			entries: []string{"192.168.0.1,fd00::1"},
+			want:    []HostAddresses{{V4: "192.168.0.1", V6: "fd00::1"}},
+		},
+		{
+			name:    "dual-stack pair with whitespace",
+			entries: []string{"192.168.0.1, fd00::1"},
+			want:    []HostAddresses{{V4: "192.168.0.1", V6: "fd00::1"}},
+		},
+		{
+			name:    "invalid ip",
+			entries: []string{"not-an-ip"},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseHostAddresses(c.entries)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseHostAddresses(%v) expected an error, got none", c.entries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHostAddresses(%v) returned error: %v", c.entries, err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("parseHostAddresses(%v) = %+v, want %+v", c.entries, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSetHostIPFamilyEnvSetsDualStack(t *testing.T) {
+	cluster := &v2.Cluster{
+		Spec: v2.ClusterSpec{
+			Env: []string{
+				v2.EnvPodCidr + "=10.96.0.0/12,fd00:1::/108",
+				v2.EnvSvcCidr + "=10.97.0.0/16,fd00:2::/112",
+			},
+		},
+	}
+	hostAddrs := []HostAddresses{{V4: "192.168.0.1", V6: "fd00::1"}}
+
+	if err := setHostIPFamilyEnv(cluster, hostAddrs); err != nil {
+		t.Fatalf("setHostIPFamilyEnv returned error: %v", err)
+	}
+
+	want := map[string]string{
+		v2.EnvHostIPFamily: string(v2.DualStack),
+		v2.EnvPodCidrV6:    "fd00:1::/108",
+		v2.EnvSvcCidrV6:    "fd00:2::/112",
+	}
+	for key, val := range want {
+		found := false
+		for _, e := range cluster.Spec.Env {
+			if e == key+"="+val {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected %s=%s in cluster env, got %v", key, val, cluster.Spec.Env)
+		}
+	}
+}
+
+func TestSetHostIPFamilyEnvSetsIPv6Only(t *testing.T) {
+	cluster := &v2.Cluster{}
+	hostAddrs := []HostAddresses{{V6: "fd00::1"}, {V6: "fd00::2"}}
+
+	if err := setHostIPFamilyEnv(cluster, hostAddrs); err != nil {
+		t.Fatalf("setHostIPFamilyEnv returned error: %v", err)
+	}
+
+	found := false
+	for _, e := range cluster.Spec.Env {
+		if e == v2.EnvHostIPFamily+"="+string(k8snet.IPv6) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %s=%s in cluster env, got %v", v2.EnvHostIPFamily, k8snet.IPv6, cluster.Spec.Env)
+	}
+}
+
+// TestRunClusterfileFunctionsRoundTripsRealCluster guards against a
+// regression to gopkg.in/yaml.v2 for the cluster<->bytes conversion: it
+// ignores v2.Cluster's `json:` tags, so ObjectMeta would round-trip as
+// "objectmeta" instead of "metadata" and any ClusterfileFunction targeting
+// metadata.* would silently stop matching.
+func TestRunClusterfileFunctionsRoundTripsRealCluster(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Clusterfile")
+	clusterfile := `kind: ClusterfileFunction
+spec:
+  image: builtin:replacement-transformer
+  values:
+    spec.image: new-image:v2
+`
+	if err := os.WriteFile(path, []byte(clusterfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := &v2.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"},
+		Spec:       v2.ClusterSpec{Image: "my-image:v1"},
+	}
+
+	out, err := runClusterfileFunctions(cluster, path)
+	if err != nil {
+		t.Fatalf("runClusterfileFunctions returned error: %v", err)
+	}
+
+	if cluster.Name != "my-cluster" {
+		t.Errorf("cluster.Name = %q after round trip, want %q", cluster.Name, "my-cluster")
+	}
+	if cluster.Spec.Image != "new-image:v2" {
+		t.Errorf("cluster.Spec.Image = %q after round trip, want %q", cluster.Spec.Image, "new-image:v2")
+	}
+	if !strings.Contains(string(out), "metadata:") {
+		t.Errorf("rendered cluster %q does not contain a metadata: stanza", out)
+	}
+	if strings.Contains(strings.ToLower(string(out)), "objectmeta") {
+		t.Errorf("rendered cluster %q leaked the Go field name objectmeta", out)
+	}
+}
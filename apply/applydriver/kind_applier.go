@@ -0,0 +1,227 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package applydriver
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+	"sigs.k8s.io/kind/pkg/cluster"
+	"sigs.k8s.io/kind/pkg/cluster/nodes"
+
+	"github.com/sealerio/sealer/common"
+	"github.com/sealerio/sealer/pkg/clusterfile"
+	"github.com/sealerio/sealer/pkg/filesystem"
+	"github.com/sealerio/sealer/pkg/image"
+	"github.com/sealerio/sealer/pkg/image/policy"
+	"github.com/sealerio/sealer/pkg/image/store"
+	v2 "github.com/sealerio/sealer/types/api/v2"
+)
+
+// KindApplier satisfies Interface like Applier does, but targets a local
+// `kind` cluster instead of SSH-reachable hosts. It lets contributors run
+// `sealer apply -f Clusterfile --provider kind` on a laptop without VMs,
+// which is handy for CI of the rest of the module.
+type KindApplier struct {
+	ClusterDesired      *v2.Cluster
+	ClusterFile         clusterfile.Interface
+	ImageManager        image.Service
+	ClusterImageMounter filesystem.Interface
+	ImageStore          store.ImageStore
+	ImagePolicy         policy.Verifier
+
+	provider *cluster.Provider
+}
+
+// NewKindApplier builds a KindApplier sharing the same image-side
+// dependencies as the SSH Applier; only the infra/runtime step differs.
+func NewKindApplier(desired *v2.Cluster, file clusterfile.Interface, imgSvc image.Service, mounter filesystem.Interface, is store.ImageStore, imagePolicy policy.Verifier) *KindApplier {
+	return &KindApplier{
+		ClusterDesired:      desired,
+		ClusterFile:         file,
+		ImageManager:        imgSvc,
+		ClusterImageMounter: mounter,
+		ImageStore:          is,
+		ImagePolicy:         imagePolicy,
+		provider:            cluster.NewProvider(),
+	}
+}
+
+func (k *KindApplier) Apply() error {
+	if err := policy.Enforce(k.ImagePolicy, k.ClusterDesired.Spec.Image); err != nil {
+		return err
+	}
+
+	if err := k.ClusterImageMounter.Mount(k.ClusterDesired); err != nil {
+		return fmt.Errorf("failed to mount cluster image: %v", err)
+	}
+	defer func() {
+		_ = k.ClusterImageMounter.Unmount(k.ClusterDesired)
+	}()
+
+	kindConfig, err := buildKindConfig(k.ClusterDesired)
+	if err != nil {
+		return err
+	}
+
+	if err := k.provider.Create(k.ClusterDesired.Name, cluster.CreateWithV1Alpha4Config(kindConfig)); err != nil {
+		return fmt.Errorf("failed to create kind cluster %s: %v", k.ClusterDesired.Name, err)
+	}
+
+	nodes, err := k.provider.ListNodes(k.ClusterDesired.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list kind nodes for cluster %s: %v", k.ClusterDesired.Name, err)
+	}
+
+	rootfs := filepath.Join(common.DefaultClusterBaseDir(k.ClusterDesired.Name), "rootfs")
+	for _, node := range nodes {
+		if err := loadRootfsIntoKindNode(rootfs, node); err != nil {
+			return fmt.Errorf("failed to load cluster image rootfs into kind node %s: %v", node.String(), err)
+		}
+	}
+
+	return nil
+}
+
+func (k *KindApplier) Delete() error {
+	return k.provider.Delete(k.ClusterDesired.Name, "")
+}
+
+// buildKindConfig synthesizes a kind v1alpha4 Cluster config from
+// cluster.Spec.Hosts, treating each host as a kind node with the requested
+// role (master -> control-plane, node -> worker).
+func buildKindConfig(desired *v2.Cluster) (*v1alpha4.Cluster, error) {
+	cfg := &v1alpha4.Cluster{}
+
+	for _, host := range desired.Spec.Hosts {
+		role := v1alpha4.WorkerRole
+		for _, r := range host.Roles {
+			if r == common.MASTER {
+				role = v1alpha4.ControlPlaneRole
+				break
+			}
+		}
+		for range host.IPS {
+			cfg.Nodes = append(cfg.Nodes, v1alpha4.Node{Role: role})
+		}
+	}
+
+	if len(cfg.Nodes) == 0 {
+		return nil, fmt.Errorf("cluster %s has no hosts to synthesize a kind config from", desired.Name)
+	}
+
+	return cfg, nil
+}
+
+// kindNodeRootfsDir is where loadRootfsIntoKindNode extracts the mounted
+// ClusterImage's rootfs on the kind node, rather than directly onto /: the
+// node's / is kind-managed (kubelet, containerd, /etc/hosts,
+// /etc/resolv.conf, /etc/hostname), and untarring an arbitrary ClusterImage
+// rootfs there risks clobbering those files.
+const kindNodeRootfsDir = "/var/lib/sealer/rootfs"
+
+// loadRootfsIntoKindNode copies the mounted ClusterImage's rootfs into a
+// kind node, mirroring `kind load` semantics instead of SSH: it streams the
+// rootfs as a tar archive over the node's stdin and extracts it under
+// kindNodeRootfsDir with the node's own `tar`, the same approach
+// `kind load docker-image` uses for image tarballs.
+func loadRootfsIntoKindNode(rootfs string, node nodes.Node) error {
+	info, err := os.Stat(rootfs)
+	if err != nil {
+		return fmt.Errorf("failed to stat rootfs %s: %v", rootfs, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("rootfs %s is not a directory", rootfs)
+	}
+
+	if err := node.Command("mkdir", "-p", kindNodeRootfsDir).Run(); err != nil {
+		return fmt.Errorf("failed to create %s on kind node %s: %v", kindNodeRootfsDir, node.String(), err)
+	}
+
+	pr, pw := io.Pipe()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tarDirectory(rootfs, pw)
+		pw.Close()
+	}()
+
+	cmd := node.Command("tar", "--warning=no-timestamp", "-C", kindNodeRootfsDir, "-xf", "-")
+	cmd.SetStdin(pr)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to extract rootfs into kind node %s: %v", node.String(), err)
+	}
+
+	if err := <-errCh; err != nil {
+		return fmt.Errorf("failed to tar rootfs %s: %v", rootfs, err)
+	}
+
+	return nil
+}
+
+// tarDirectory writes root's contents to w as a tar stream, with paths
+// relative to root.
+func tarDirectory(root string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return fmt.Errorf("failed to read symlink %s: %v", p, err)
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package applydriver
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarDirectory(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte("kind-node\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("hostname", filepath.Join(root, "etc", "hostname-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tarDirectory(root, &buf); err != nil {
+		t.Fatalf("tarDirectory failed: %v", err)
+	}
+
+	tr := tar.NewReader(&buf)
+	var names []string
+	var gotContent, gotLinkname string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == filepath.Join("etc", "hostname") {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotContent = string(data)
+		}
+		if hdr.Name == filepath.Join("etc", "hostname-link") {
+			gotLinkname = hdr.Linkname
+		}
+	}
+
+	if gotContent != "kind-node\n" {
+		t.Fatalf("got file content %q, want %q", gotContent, "kind-node\n")
+	}
+	if gotLinkname != "hostname" {
+		t.Fatalf("got symlink target %q, want %q", gotLinkname, "hostname")
+	}
+	if len(names) == 0 {
+		t.Fatal("expected tarDirectory to write at least one entry")
+	}
+}
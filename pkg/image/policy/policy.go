@@ -0,0 +1,411 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy implements cosign-style signature verification for
+// ClusterImages, modeled on sigstore's ClusterImagePolicy CRD: a set of
+// accepted identities (keyless subject/issuer pairs or raw public keys)
+// keyed by an image reference glob.
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sirupsen/logrus"
+
+	"github.com/sealerio/sealer/pkg/yamlutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPolicyFile and EnvInsecureSkipVerify are the cluster env vars
+// `loadImagePolicy` reads to locate a `--policy-file` and to honor
+// `--insecure-skip-verify`. They live here, rather than on types/api/v2,
+// so that ClusterImagePolicy's on-disk wire format and its env plumbing
+// stay in one place.
+const (
+	EnvPolicyFile         = "SEALER_POLICY_FILE"
+	EnvInsecureSkipVerify = "SEALER_INSECURE_SKIP_VERIFY"
+)
+
+// Identity is one acceptable signer for images matching a glob. Either
+// PublicKey is set (classic cosign key-pair signing), or Subject+Issuer are
+// set (keyless/Fulcio signing). Only PublicKey identities are verified:
+// LoadFromFile and LoadFromClusterfile reject a policy holding any
+// Subject/Issuer identity (see validateNoKeylessIdentities).
+type Identity struct {
+	Subject   string `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Issuer    string `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+	PublicKey string `json:"publicKey,omitempty" yaml:"publicKey,omitempty"`
+}
+
+// Rule binds a glob over image references to the identities allowed to sign
+// them. At least one Identity must be satisfied by at least one signature.
+type Rule struct {
+	ImageGlob  string     `json:"imageGlob" yaml:"imageGlob"`
+	Identities []Identity `json:"identities" yaml:"identities"`
+}
+
+// ClusterImagePolicy is the top-level policy document, loadable from a
+// standalone `--policy-file` or from a `ClusterImagePolicy` stanza embedded
+// in the Clusterfile.
+type ClusterImagePolicy struct {
+	Kind  string `json:"kind" yaml:"kind"`
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Verifier checks a resolved image digest against a ClusterImagePolicy.
+type Verifier interface {
+	// Verify returns nil if imageName@digest satisfies the policy, and an
+	// error describing why it was rejected otherwise.
+	Verify(imageName, digest string) error
+}
+
+// cachedVerifier wraps a ClusterImagePolicy with a per-digest result cache
+// so repeated applies of the same ClusterImage do not re-verify signatures
+// that already passed.
+type cachedVerifier struct {
+	policy *ClusterImagePolicy
+
+	mu    sync.Mutex
+	cache map[string]error
+}
+
+// NewVerifier builds a Verifier from an already-parsed policy. A nil policy
+// produces a Verifier whose Verify always succeeds, which lets callers keep
+// a single code path whether or not a policy was configured.
+func NewVerifier(p *ClusterImagePolicy) Verifier {
+	return &cachedVerifier{policy: p, cache: map[string]error{}}
+}
+
+// LoadFromFile reads a ClusterImagePolicy from a YAML file, as pointed to
+// by `--policy-file`.
+func LoadFromFile(policyFile string) (*ClusterImagePolicy, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %v", policyFile, err)
+	}
+
+	var p ClusterImagePolicy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %v", policyFile, err)
+	}
+	if err := validateNoKeylessIdentities(&p); err != nil {
+		return nil, fmt.Errorf("%s: %v", policyFile, err)
+	}
+
+	return &p, nil
+}
+
+// validateNoKeylessIdentities rejects any Identity that relies on Fulcio
+// keyless signing (Subject/Issuer). identityMatchesSignature can verify
+// that a keyless cert's embedded public key signed the payload, but it does
+// not walk the cert's chain back to a trusted Fulcio root, so accepting
+// these identities would let a self-signed cert with a matching SAN forge
+// any identity. Reject the policy outright rather than silently never
+// matching it, until that root-of-trust check exists.
+func validateNoKeylessIdentities(p *ClusterImagePolicy) error {
+	for _, rule := range p.Rules {
+		for _, id := range rule.Identities {
+			if id.Subject != "" || id.Issuer != "" {
+				return fmt.Errorf("rule %q: keyless (subject/issuer) identities are not supported yet, no Fulcio root-of-trust verification is implemented; use a publicKey identity instead", rule.ImageGlob)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFromClusterfile scans the (possibly multi-document) Clusterfile at
+// path for an embedded `kind: ClusterImagePolicy` stanza and returns it.
+// It returns (nil, nil) when no such stanza is present, which callers treat
+// the same as "no policy configured". Clusterfiles are parsed here instead
+// of through clusterfile.Interface so that adding a new embeddable stanza
+// kind doesn't require growing that interface.
+func LoadFromClusterfile(path string) (*ClusterImagePolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Clusterfile %s: %v", path, err)
+	}
+
+	for _, doc := range yamlutil.SplitDocuments(data) {
+		var probe struct {
+			Kind string `yaml:"kind"`
+		}
+		if err := yaml.Unmarshal(doc, &probe); err != nil || probe.Kind != "ClusterImagePolicy" {
+			continue
+		}
+
+		var p ClusterImagePolicy
+		if err := yaml.Unmarshal(doc, &p); err != nil {
+			return nil, fmt.Errorf("failed to parse ClusterImagePolicy in %s: %v", path, err)
+		}
+		if err := validateNoKeylessIdentities(&p); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return &p, nil
+	}
+
+	return nil, nil
+}
+
+func (c *cachedVerifier) Verify(imageName, digest string) error {
+	if c.policy == nil {
+		return nil
+	}
+
+	cacheKey := imageName + "@" + digest
+
+	c.mu.Lock()
+	if cached, ok := c.cache[cacheKey]; ok {
+		c.mu.Unlock()
+		return cached
+	}
+	c.mu.Unlock()
+
+	err := c.verifyUncached(imageName, digest)
+
+	c.mu.Lock()
+	c.cache[cacheKey] = err
+	c.mu.Unlock()
+
+	return err
+}
+
+func (c *cachedVerifier) verifyUncached(imageName, digest string) error {
+	rule := c.matchRule(imageName)
+	if rule == nil {
+		return fmt.Errorf("image %s does not match any rule in the configured ClusterImagePolicy", imageName)
+	}
+
+	sigs, err := fetchSignaturesFn(imageName, digest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signatures for %s: %v", imageName, err)
+	}
+
+	for _, sig := range sigs {
+		for _, id := range rule.Identities {
+			if identityMatchesSignature(id, sig, digest) {
+				auditLog(imageName, digest, id, true)
+				return nil
+			}
+		}
+	}
+
+	auditLog(imageName, digest, Identity{}, false)
+	return fmt.Errorf("no signature for %s (digest %s) satisfies the configured identities", imageName, digest)
+}
+
+func (c *cachedVerifier) matchRule(imageName string) *Rule {
+	for i := range c.policy.Rules {
+		if ok, _ := path.Match(c.policy.Rules[i].ImageGlob, imageName); ok {
+			return &c.policy.Rules[i]
+		}
+	}
+	return nil
+}
+
+// signature is one cosign signature layer pulled from the `sha256-<digest>.sig`
+// tag, prior to verification.
+type signature struct {
+	Payload   []byte
+	Signature []byte
+	Cert      []byte
+}
+
+const (
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignCertAnnotation      = "dev.cosignproject.cosign/certificate"
+)
+
+// fetchSignaturesFn is a package-level seam so tests can stub out the
+// registry round-trip without a real cosign-signed image.
+var fetchSignaturesFn = fetchSignatures
+
+// fetchSignatures resolves the `sha256-<digest>.sig` tag in the same
+// registry as imageName, following cosign's convention, and returns its
+// signature layers. Each layer's payload is the signed blob (the "simple
+// signing" envelope) and its base64 signature/cert live in the manifest
+// layer's annotations, the same shape `cosign sign` publishes. A missing
+// .sig tag is reported as zero signatures, not an error, since an image
+// with no published signatures should fail Verify the same way one with
+// non-matching signatures does.
+func fetchSignatures(imageName, digest string) ([]signature, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference %s: %v", imageName, err)
+	}
+
+	sigTag := fmt.Sprintf("%s:sha256-%s.sig", ref.Context().Name(), strings.TrimPrefix(digest, "sha256:"))
+	sigRef, err := name.ParseReference(sigTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signature tag %s: %v", sigTag, err)
+	}
+
+	img, err := remote.Image(sigRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to pull signatures from %s: %v", sigTag, err)
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature manifest %s: %v", sigTag, err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signature layers of %s: %v", sigTag, err)
+	}
+	if len(layers) != len(manifest.Layers) {
+		return nil, fmt.Errorf("signature manifest %s has %d layer descriptors but %d layers", sigTag, len(manifest.Layers), len(layers))
+	}
+
+	sigs := make([]signature, 0, len(layers))
+	for i, l := range layers {
+		ann := manifest.Layers[i].Annotations
+		sigB64 := ann[cosignSignatureAnnotation]
+		if sigB64 == "" {
+			continue
+		}
+
+		sigBytes, err := base64.StdEncoding.DecodeString(sigB64)
+		if err != nil {
+			return nil, fmt.Errorf("signature layer %d of %s has invalid base64 signature: %v", i, sigTag, err)
+		}
+
+		rc, err := l.Uncompressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open signature layer %d of %s: %v", i, sigTag, err)
+		}
+		payload, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature layer %d of %s: %v", i, sigTag, err)
+		}
+
+		sigs = append(sigs, signature{
+			Payload:   payload,
+			Signature: sigBytes,
+			Cert:      []byte(ann[cosignCertAnnotation]),
+		})
+	}
+
+	return sigs, nil
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	return terr.StatusCode == http.StatusNotFound
+}
+
+// simpleSigningPayload is cosign's "simple signing" envelope: the payload
+// that actually gets signed, binding a signature to one specific manifest
+// digest so it cannot be replayed against a different image.
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// payloadMatchesDigest reports whether sig's signed payload is a simple
+// signing envelope for exactly digest. Without this check a validly signed
+// payload for one image could be copied onto any other image's .sig tag and
+// pass verification; this is what binds the signature to the image actually
+// being applied.
+func payloadMatchesDigest(payload []byte, digest string) bool {
+	var p simpleSigningPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return false
+	}
+	return p.Critical.Image.DockerManifestDigest == digest
+}
+
+// identityMatchesSignature checks a signature's payload against an accepted
+// Identity's public key, and that the payload is a simple signing envelope
+// for digest rather than one signed for a different image. Subject/Issuer
+// (Fulcio keyless) identities are rejected by validateNoKeylessIdentities
+// before a ClusterImagePolicy ever reaches a Verifier, since verifying the
+// cert's own signature over the payload isn't enough without also walking
+// its chain back to a trusted Fulcio root: a self-signed cert with a
+// matching SAN would otherwise pass. This switch still fails closed on that
+// case as a second line of defense.
+func identityMatchesSignature(id Identity, sig signature, digest string) bool {
+	if !payloadMatchesDigest(sig.Payload, digest) {
+		return false
+	}
+
+	switch {
+	case id.PublicKey != "":
+		pub, err := parseECDSAPublicKey(id.PublicKey)
+		if err != nil {
+			return false
+		}
+		return verifyECDSA(pub, sig.Payload, sig.Signature)
+
+	default:
+		return false
+	}
+}
+
+func parseECDSAPublicKey(pemStr string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, fmt.Errorf("identity publicKey is not PEM-encoded")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("only ECDSA public keys are supported, got %T", pub)
+	}
+	return ecPub, nil
+}
+
+func verifyECDSA(pub *ecdsa.PublicKey, payload, sig []byte) bool {
+	digest := sha256.Sum256(payload)
+	return ecdsa.VerifyASN1(pub, digest[:], sig)
+}
+
+func auditLog(imageName, digest string, id Identity, matched bool) {
+	logrus.WithFields(logrus.Fields{
+		"image":    imageName,
+		"digest":   digest,
+		"matched":  matched,
+		"identity": id,
+	}).Info("image-policy: signature check")
+}
@@ -0,0 +1,176 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestMatchRuleGlob(t *testing.T) {
+	p := &ClusterImagePolicy{
+		Rules: []Rule{
+			{ImageGlob: "registry.example.com/team-a/*"},
+			{ImageGlob: "docker.io/library/nginx:*"},
+		},
+	}
+	v := &cachedVerifier{policy: p, cache: map[string]error{}}
+
+	cases := []struct {
+		image string
+		want  bool
+	}{
+		{"registry.example.com/team-a/app:v1", true},
+		{"registry.example.com/team-b/app:v1", false},
+		{"docker.io/library/nginx:1.25", true},
+		{"docker.io/library/redis:7", false},
+	}
+
+	for _, c := range cases {
+		got := v.matchRule(c.image) != nil
+		if got != c.want {
+			t.Errorf("matchRule(%q) = %v, want %v", c.image, got, c.want)
+		}
+	}
+}
+
+func TestVerifyRejectsUnmatchedImage(t *testing.T) {
+	v := NewVerifier(&ClusterImagePolicy{Rules: []Rule{{ImageGlob: "registry.example.com/team-a/*"}}})
+
+	if err := v.Verify("registry.example.com/team-b/app", "sha256:deadbeef"); err == nil {
+		t.Fatal("expected Verify to reject an image matching no rule")
+	}
+}
+
+func TestVerifyNilPolicyAlwaysPasses(t *testing.T) {
+	v := NewVerifier(nil)
+	if err := v.Verify("anything", "sha256:deadbeef"); err != nil {
+		t.Fatalf("nil policy should never reject, got: %v", err)
+	}
+}
+
+func TestVerifyCachesResult(t *testing.T) {
+	calls := 0
+	orig := fetchSignaturesFn
+	fetchSignaturesFn = func(imageName, digest string) ([]signature, error) {
+		calls++
+		return nil, nil
+	}
+	defer func() { fetchSignaturesFn = orig }()
+
+	v := NewVerifier(&ClusterImagePolicy{Rules: []Rule{{ImageGlob: "registry.example.com/*"}}})
+
+	_ = v.Verify("registry.example.com/app", "sha256:deadbeef")
+	_ = v.Verify("registry.example.com/app", "sha256:deadbeef")
+
+	if calls != 1 {
+		t.Fatalf("fetchSignatures called %d times, want 1 (second Verify should hit the cache)", calls)
+	}
+}
+
+func TestIdentityMatchesSignaturePublicKey(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	const digest = "sha256:deadbeef"
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"` + digest + `"}}}`)
+	sigDigest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, sigDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := Identity{PublicKey: string(pubPEM)}
+	good := signature{Payload: payload, Signature: sig}
+	if !identityMatchesSignature(id, good, digest) {
+		t.Fatal("expected identityMatchesSignature to accept a correctly signed payload for the matching digest")
+	}
+
+	tampered := signature{Payload: []byte("something else"), Signature: sig}
+	if identityMatchesSignature(id, tampered, digest) {
+		t.Fatal("expected identityMatchesSignature to reject a tampered payload")
+	}
+}
+
+func TestIdentityMatchesSignatureRejectsDigestMismatch(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	// A validly signed payload for a *different* image's digest must not be
+	// accepted as a signature over "sha256:deadbeef" - otherwise a signature
+	// could be replayed from any other signed image onto this one.
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"sha256:otherimage"}}}`)
+	sigDigest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, sigDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := Identity{PublicKey: string(pubPEM)}
+	replayed := signature{Payload: payload, Signature: sig}
+	if identityMatchesSignature(id, replayed, "sha256:deadbeef") {
+		t.Fatal("expected identityMatchesSignature to reject a signature whose payload names a different digest")
+	}
+}
+
+func TestValidateNoKeylessIdentitiesRejectsSubject(t *testing.T) {
+	p := &ClusterImagePolicy{Rules: []Rule{{
+		ImageGlob:  "registry.example.com/team-a/*",
+		Identities: []Identity{{Subject: "builder@example.com", Issuer: "https://accounts.example.com"}},
+	}}}
+
+	if err := validateNoKeylessIdentities(p); err == nil {
+		t.Fatal("expected validateNoKeylessIdentities to reject a subject/issuer identity")
+	}
+}
+
+func TestValidateNoKeylessIdentitiesAllowsPublicKey(t *testing.T) {
+	p := &ClusterImagePolicy{Rules: []Rule{{
+		ImageGlob:  "registry.example.com/team-a/*",
+		Identities: []Identity{{PublicKey: "-----BEGIN PUBLIC KEY-----\n...\n-----END PUBLIC KEY-----"}},
+	}}}
+
+	if err := validateNoKeylessIdentities(p); err != nil {
+		t.Fatalf("validateNoKeylessIdentities rejected a publicKey-only identity: %v", err)
+	}
+}
+
+func TestIdentityMatchesSignatureRejectsUnknownKey(t *testing.T) {
+	id := Identity{PublicKey: "not a pem"}
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"sha256:deadbeef"}}}`)
+	if identityMatchesSignature(id, signature{Payload: payload, Signature: []byte("y")}, "sha256:deadbeef") {
+		t.Fatal("expected identityMatchesSignature to reject an unparseable public key")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ResolveDigest resolves imageName's manifest digest straight from its
+// registry. It lives here, rather than on image.Service, because enforcing
+// a ClusterImagePolicy is the only caller that needs a digest ahead of the
+// local pull, and image.Service isn't (yet) able to hand one back.
+func ResolveDigest(imageName string) (string, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %v", imageName, err)
+	}
+
+	desc, err := remote.Get(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve digest for %s: %v", imageName, err)
+	}
+
+	return desc.Digest.String(), nil
+}
+
+// Enforce resolves imageName's digest and checks it against v. It is a
+// no-op when v is nil, so both appliers can call it unconditionally
+// regardless of whether a ClusterImagePolicy was configured, instead of
+// each repeating the same resolve-then-verify block.
+func Enforce(v Verifier, imageName string) error {
+	if v == nil {
+		return nil
+	}
+
+	digest, err := ResolveDigest(imageName)
+	if err != nil {
+		return fmt.Errorf("failed to resolve digest for image %s: %v", imageName, err)
+	}
+
+	if err := v.Verify(imageName, digest); err != nil {
+		return fmt.Errorf("image %s failed signature verification: %v", imageName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,325 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pullbackend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// defaultCacheDir is the content-addressable blob cache, keyed by digest so
+// that repeated `sealer apply` runs reuse layers instead of re-downloading
+// them.
+const defaultCacheDir = "/var/lib/sealer/cache/blobs/sha256"
+
+// maxConcurrentLayerPulls bounds the worker pool used to download a single
+// image's layers in parallel.
+const maxConcurrentLayerPulls = 4
+
+// envRegistryMirrors is a comma-separated list of registry hosts consulted,
+// in order, before the image reference's own registry. Mirrors are tried
+// with fallthrough on 404/401.
+const envRegistryMirrors = "SEALER_REGISTRY_MIRRORS"
+
+func mirrorsFromEnv() []string {
+	raw := os.Getenv(envRegistryMirrors)
+	if raw == "" {
+		return nil
+	}
+
+	var mirrors []string
+	for _, m := range strings.Split(raw, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			mirrors = append(mirrors, m)
+		}
+	}
+	return mirrors
+}
+
+// GGCRBackend is a Backend built on go-containerregistry, modeled on
+// rke2's embedded wharfie/crane usage: it tries each mirror in order,
+// caches blobs on disk by digest, downloads layers concurrently with a
+// bounded worker pool, and resumes interrupted downloads with HTTP Range.
+type GGCRBackend struct {
+	Mirrors  []string
+	CacheDir string
+}
+
+// NewGGCRBackend builds a GGCRBackend. cacheDir is created lazily on first
+// Pull.
+func NewGGCRBackend(mirrors []string, cacheDir string) *GGCRBackend {
+	return &GGCRBackend{Mirrors: mirrors, CacheDir: cacheDir}
+}
+
+func (b *GGCRBackend) Pull(imageName string) ([]string, error) {
+	if err := os.MkdirAll(b.CacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob cache dir %s: %v", b.CacheDir, err)
+	}
+
+	img, ref, err := b.resolveWithMirrors(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list layers for %s (resolved via %s): %v", imageName, ref, err)
+	}
+
+	paths := make([]string, len(layers))
+	errs := make([]error, len(layers))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentLayerPulls)
+
+	for i, layer := range layers {
+		i, layer := i, layer
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			digest, err := layer.Digest()
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to get digest for layer %d of %s: %v", i, imageName, err)
+				return
+			}
+
+			path, err := b.fetchLayer(ref, layer, digest.String())
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			paths[i] = path
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// rewriteRegistry swaps imageName's registry host for mirror, leaving the
+// repository and tag/digest untouched. An empty mirror returns imageName
+// unchanged, which is how resolveWithMirrors falls back to the image's own
+// registry after exhausting the configured mirrors.
+func rewriteRegistry(imageName, mirror string) (string, error) {
+	if mirror == "" {
+		return imageName, nil
+	}
+
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %s: %v", imageName, err)
+	}
+
+	repo := ref.Context().RepositoryStr()
+	switch r := ref.(type) {
+	case name.Tag:
+		return fmt.Sprintf("%s/%s:%s", mirror, repo, r.TagStr()), nil
+	case name.Digest:
+		return fmt.Sprintf("%s/%s@%s", mirror, repo, r.DigestStr()), nil
+	default:
+		return "", fmt.Errorf("unsupported reference type for %s", imageName)
+	}
+}
+
+// resolveWithMirrors tries each configured mirror in order before falling
+// back to imageName's own registry, returning the first image that
+// resolves (and the reference it resolved from, needed by fetchLayer to
+// build blob URLs for Range requests). Mirrors that answer 404/401 fall
+// through to the next.
+func (b *GGCRBackend) resolveWithMirrors(imageName string) (v1.Image, name.Reference, error) {
+	candidates := append(append([]string{}, b.Mirrors...), "")
+
+	var lastErr error
+	for _, mirror := range candidates {
+		rewritten, err := rewriteRegistry(imageName, mirror)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		parsed, err := name.ParseReference(rewritten)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		img, err := remote.Image(parsed, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			if isNotFoundOrUnauthorized(err) {
+				lastErr = err
+				continue
+			}
+			return nil, nil, fmt.Errorf("failed to pull %s from %s: %v", imageName, rewritten, err)
+		}
+
+		return img, parsed, nil
+	}
+
+	return nil, nil, fmt.Errorf("failed to resolve %s from any configured registry mirror: %v", imageName, lastErr)
+}
+
+// fetchLayer writes a single layer's blob to the content-addressable cache,
+// resuming a partial download with an HTTP Range request against ref's
+// registry if a partial file is already on disk, and verifying the result
+// against digest either way.
+func (b *GGCRBackend) fetchLayer(ref name.Reference, layer v1.Layer, digest string) (string, error) {
+	// digest is "sha256:<hex>"; the cache keys on the hex part only, per
+	// the existing /var/lib/sealer/cache/blobs/sha256/<digest> layout.
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	dest := filepath.Join(b.CacheDir, hexDigest)
+
+	var resumeFrom int64
+	if info, err := os.Stat(dest); err == nil && info.Size() > 0 {
+		if complete, err := layerSizeMatches(layer, info.Size()); err == nil && complete {
+			if ok, err := fileDigestMatches(dest, hexDigest); err == nil && ok {
+				return dest, nil
+			}
+			// A full-size file with the wrong bytes is no prefix to resume
+			// from: download the whole blob again (resumeFrom stays 0).
+		} else {
+			// A file of a different size is taken as an interrupted
+			// download and resumed via Range from its end.
+			resumeFrom = info.Size()
+		}
+	}
+
+	if err := b.downloadLayerRange(ref, digest, dest, resumeFrom); err != nil {
+		return "", err
+	}
+
+	ok, err := fileDigestMatches(dest, hexDigest)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify downloaded layer %s: %v", digest, err)
+	}
+	if !ok {
+		_ = os.Remove(dest)
+		return "", fmt.Errorf("downloaded layer %s does not match its digest", digest)
+	}
+
+	return dest, nil
+}
+
+// downloadLayerRange GETs digest's blob from ref's registry, issuing a
+// Range request for the bytes after resumeFrom when resumeFrom > 0. A 206
+// response appends to dest; a 200 response means the registry ignored the
+// Range header (some do), so the body is the full blob and dest is
+// truncated first rather than appended to, which is what actually
+// corrupted the cache before this fix.
+func (b *GGCRBackend) downloadLayerRange(ref name.Reference, digest, dest string, resumeFrom int64) error {
+	auth, err := authn.DefaultKeychain.Resolve(ref.Context())
+	if err != nil {
+		return fmt.Errorf("failed to resolve credentials for %s: %v", ref.Context(), err)
+	}
+
+	rt, err := transport.NewWithContext(context.Background(), ref.Context().Registry, auth, http.DefaultTransport, []string{ref.Context().Scope(transport.PullScope)})
+	if err != nil {
+		return fmt.Errorf("failed to build registry transport for layer %s: %v", digest, err)
+	}
+
+	blobURL := fmt.Sprintf("%s://%s/v2/%s/blobs/%s", ref.Context().Scheme(), ref.Context().RegistryStr(), ref.Context().RepositoryStr(), digest)
+
+	req, err := http.NewRequest(http.MethodGet, blobURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build blob request for layer %s: %v", digest, err)
+	}
+	if resumeFrom > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+	}
+
+	resp, err := (&http.Client{Transport: rt}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch layer %s: %v", digest, err)
+	}
+	defer resp.Body.Close()
+
+	var flags int
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	case http.StatusOK:
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	default:
+		return fmt.Errorf("failed to fetch layer %s: unexpected status %s from %s", digest, resp.Status, blobURL)
+	}
+
+	f, err := os.OpenFile(dest, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open cache file for layer %s: %v", digest, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("failed to download layer %s: %v", digest, err)
+	}
+
+	return nil
+}
+
+func layerSizeMatches(layer v1.Layer, onDisk int64) (bool, error) {
+	size, err := layer.Size()
+	if err != nil {
+		return false, err
+	}
+	return size == onDisk, nil
+}
+
+// fileDigestMatches reports whether path's sha256 hex digest equals
+// wantHex.
+func fileDigestMatches(path, wantHex string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == wantHex, nil
+}
+
+func isNotFoundOrUnauthorized(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	return terr.StatusCode == http.StatusNotFound || terr.StatusCode == http.StatusUnauthorized
+}
@@ -0,0 +1,108 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pullbackend
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRewriteRegistry(t *testing.T) {
+	cases := []struct {
+		name      string
+		imageName string
+		mirror    string
+		want      string
+	}{
+		{"no mirror returns unchanged", "docker.io/library/nginx:1.25", "", "docker.io/library/nginx:1.25"},
+		{"tag reference", "docker.io/library/nginx:1.25", "mirror.example.com", "mirror.example.com/library/nginx:1.25"},
+		{"digest reference", "docker.io/library/nginx@sha256:" + fakeDigestHex, "mirror.example.com", "mirror.example.com/library/nginx@sha256:" + fakeDigestHex},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := rewriteRegistry(c.imageName, c.mirror)
+			if err != nil {
+				t.Fatalf("rewriteRegistry(%q, %q) returned error: %v", c.imageName, c.mirror, err)
+			}
+			if got != c.want {
+				t.Errorf("rewriteRegistry(%q, %q) = %q, want %q", c.imageName, c.mirror, got, c.want)
+			}
+		})
+	}
+}
+
+const fakeDigestHex = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestMirrorsFromEnv(t *testing.T) {
+	t.Setenv(envRegistryMirrors, "")
+	if got := mirrorsFromEnv(); got != nil {
+		t.Errorf("mirrorsFromEnv() with unset env = %v, want nil", got)
+	}
+
+	t.Setenv(envRegistryMirrors, "mirror-a.example.com, mirror-b.example.com ,")
+	want := []string{"mirror-a.example.com", "mirror-b.example.com"}
+	got := mirrorsFromEnv()
+	if len(got) != len(want) {
+		t.Fatalf("mirrorsFromEnv() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("mirrorsFromEnv()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSelectFromEnvDefault(t *testing.T) {
+	t.Setenv(EnvPullBackend, "")
+	if b := SelectFromEnv(); b != nil {
+		t.Errorf("SelectFromEnv() with unset backend = %v, want nil", b)
+	}
+}
+
+func TestSelectFromEnvGGCR(t *testing.T) {
+	t.Setenv(EnvPullBackend, BackendGGCR)
+	b := SelectFromEnv()
+	if _, ok := b.(*GGCRBackend); !ok {
+		t.Errorf("SelectFromEnv() = %T, want *GGCRBackend", b)
+	}
+}
+
+func TestFileDigestMatches(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/blob"
+	if err := os.WriteFile(path, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// sha256("hello world")
+	const want = "b94d27b9934d3e08a52e52d7da7dacefac62b11021c5dd1c2da6f6f93ee7db6"
+
+	ok, err := fileDigestMatches(path, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("fileDigestMatches: expected match for correct digest")
+	}
+
+	ok, err = fileDigestMatches(path, "deadbeef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("fileDigestMatches: expected mismatch for wrong digest")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pullbackend provides alternative implementations of the layer
+// pull image.NewImageService does by default, so that rke2-style embedded
+// wharfie/crane usage (registry mirrors, a content-addressable blob cache,
+// concurrent and resumable downloads) can be opted into without touching
+// the default pull path.
+//
+// image.Service does not take a Backend yet, so nothing in this module
+// calls SelectFromEnv/Backend.Pull today: wiring a pre-pull ahead of an
+// unmodified image.Service only downloads every layer twice, since
+// image.Service never reads this package's on-disk blob cache. Giving
+// image.Service a real seam onto a Backend (so its own pull consumes the
+// cache this package fills) is not done in this module.
+package pullbackend
+
+import "os"
+
+// EnvPullBackend is read by SelectFromEnv: BackendGGCR selects the
+// go-containerregistry backend; any other value (including unset) selects
+// none. Since nothing in this module calls SelectFromEnv, setting it does
+// not change how `sealer apply` pulls images.
+const EnvPullBackend = "SEALER_PULL_BACKEND"
+
+// BackendGGCR is the EnvPullBackend value that selects NewGGCRBackend.
+const BackendGGCR = "ggcr"
+
+// Backend pulls a single image reference's layers to local disk ahead of
+// image.Service's own pull. It is the seam a new pull strategy plugs into
+// without the rest of this package caring how layers actually got there.
+type Backend interface {
+	// Pull fetches every layer of imageName and returns the local paths of
+	// the downloaded layer blobs, in the manifest's order.
+	Pull(imageName string) ([]string, error)
+}
+
+// SelectFromEnv returns the Backend requested via EnvPullBackend, or nil
+// when the default (pre-existing) pull path should be used. Callers treat
+// a nil Backend as "keep doing what image.NewImageService already did".
+func SelectFromEnv() Backend {
+	if os.Getenv(EnvPullBackend) != BackendGGCR {
+		return nil
+	}
+
+	return NewGGCRBackend(mirrorsFromEnv(), defaultCacheDir)
+}
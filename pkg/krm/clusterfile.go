@@ -0,0 +1,55 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krm
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sealerio/sealer/pkg/yamlutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadFunctionsFromClusterfile reads every `kind: ClusterfileFunction`
+// document out of the (possibly multi-document) Clusterfile at path, in the
+// order they appear, for Run to execute as a pipeline. Clusterfiles are
+// parsed here instead of through clusterfile.Interface so that adding a new
+// embeddable stanza kind doesn't require growing that interface, same as
+// pkg/image/policy.LoadFromClusterfile.
+func LoadFunctionsFromClusterfile(path string) ([]ClusterfileFunction, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Clusterfile %s: %v", path, err)
+	}
+
+	var functions []ClusterfileFunction
+	for _, doc := range yamlutil.SplitDocuments(data) {
+		var probe struct {
+			Kind string `yaml:"kind"`
+		}
+		if err := yaml.Unmarshal(doc, &probe); err != nil || probe.Kind != FunctionKind {
+			continue
+		}
+
+		var fn ClusterfileFunction
+		if err := yaml.Unmarshal(doc, &fn); err != nil {
+			return nil, fmt.Errorf("failed to parse %s document in %s: %v", FunctionKind, path, err)
+		}
+		functions = append(functions, fn)
+	}
+
+	return functions, nil
+}
@@ -0,0 +1,82 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testClusterfile = `apiVersion: sealer.io/v2
+kind: Cluster
+metadata:
+  name: my-cluster
+spec:
+  image: my-image:v1
+---
+kind: ClusterfileFunction
+spec:
+  image: builtin:replacement-transformer
+  values:
+    spec.image: my-image:v2
+---
+kind: ClusterImagePolicy
+spec:
+  rules: []
+---
+kind: ClusterfileFunction
+spec:
+  image: ghcr.io/example/transformer:v1
+`
+
+func TestLoadFunctionsFromClusterfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Clusterfile")
+	if err := os.WriteFile(path, []byte(testClusterfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	functions, err := LoadFunctionsFromClusterfile(path)
+	if err != nil {
+		t.Fatalf("LoadFunctionsFromClusterfile returned error: %v", err)
+	}
+
+	if len(functions) != 2 {
+		t.Fatalf("got %d functions, want 2 (Cluster and ClusterImagePolicy stanzas should be skipped)", len(functions))
+	}
+	if functions[0].Spec.Image != BuiltinReplacementTransformer {
+		t.Errorf("functions[0].Spec.Image = %q, want %q", functions[0].Spec.Image, BuiltinReplacementTransformer)
+	}
+	if functions[1].Spec.Image != "ghcr.io/example/transformer:v1" {
+		t.Errorf("functions[1].Spec.Image = %q, want ghcr.io/example/transformer:v1", functions[1].Spec.Image)
+	}
+}
+
+func TestLoadFunctionsFromClusterfileNoFunctions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Clusterfile")
+	if err := os.WriteFile(path, []byte("kind: Cluster\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	functions, err := LoadFunctionsFromClusterfile(path)
+	if err != nil {
+		t.Fatalf("LoadFunctionsFromClusterfile returned error: %v", err)
+	}
+	if functions != nil {
+		t.Errorf("LoadFunctionsFromClusterfile with no functions = %v, want nil", functions)
+	}
+}
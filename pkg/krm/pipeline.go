@@ -0,0 +1,163 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package krm implements a Kustomize/KRM-function-style pre-processing
+// pipeline for Clusterfiles, mirroring how airshipctl moved clusterctl to
+// KRM functions: a list of transformer images declared in the Clusterfile
+// (`kind: ClusterfileFunction`) each receive the parsed Clusterfile as a
+// ResourceList over stdin and return a transformed ResourceList on stdout.
+// This lets users template, substitute values, or mutate policy (inject
+// mirrors, labels, extra hosts) without forking sealer.
+package krm
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FunctionKind is the `kind` a Clusterfile stanza must declare to be picked
+// up as a pipeline step.
+const FunctionKind = "ClusterfileFunction"
+
+// BuiltinReplacementTransformer is the reserved `spec.image` value that
+// runs the in-tree replacement-transformer instead of spawning a
+// container, so common value-substitution cases work with no external
+// images.
+const BuiltinReplacementTransformer = "builtin:replacement-transformer"
+
+// ClusterfileFunction is one transformer step, as declared in the
+// Clusterfile:
+//
+//	kind: ClusterfileFunction
+//	spec:
+//	  image: ghcr.io/org/replacement-transformer:v1
+//	  valuesFrom: values.yaml
+type ClusterfileFunction struct {
+	Kind string `yaml:"kind"`
+	Spec struct {
+		Image      string                 `yaml:"image"`
+		ValuesFrom string                 `yaml:"valuesFrom,omitempty"`
+		Values     map[string]interface{} `yaml:"values,omitempty"`
+	} `yaml:"spec"`
+}
+
+// ResourceList is the KRM function wire format: the resources being
+// transformed, plus the function's own config. Sealer only ever puts one
+// resource (the Clusterfile's Cluster document) on Items, but the format
+// stays list-shaped for compatibility with the wider KRM function
+// ecosystem.
+type ResourceList struct {
+	APIVersion     string                   `yaml:"apiVersion"`
+	Kind           string                   `yaml:"kind"`
+	Items          []map[string]interface{} `yaml:"items"`
+	FunctionConfig map[string]interface{}   `yaml:"functionConfig,omitempty"`
+}
+
+// ContainerRunner runs a single KRM function container, writing
+// resourceList to its stdin and returning whatever it wrote to stdout.
+// Implementations are expected to wrap sealer's existing container
+// runtime; Run must be deterministic for a given (image, resourceList,
+// config) so the pipeline can be dry-run with --render-only.
+type ContainerRunner interface {
+	Run(image string, resourceList []byte) ([]byte, error)
+}
+
+// Run executes functions in order against clusterYAML (the parsed
+// Clusterfile's Cluster document, re-marshaled to YAML), returning the
+// final transformed document. A nil functions list is a no-op that
+// returns clusterYAML unchanged.
+func Run(clusterYAML []byte, functions []ClusterfileFunction, runner ContainerRunner) ([]byte, error) {
+	if len(functions) == 0 {
+		return clusterYAML, nil
+	}
+
+	var cluster map[string]interface{}
+	if err := yaml.Unmarshal(clusterYAML, &cluster); err != nil {
+		return nil, fmt.Errorf("failed to parse Clusterfile for the KRM pipeline: %v", err)
+	}
+
+	rl := ResourceList{
+		APIVersion: "config.kubernetes.io/v1",
+		Kind:       "ResourceList",
+		Items:      []map[string]interface{}{cluster},
+	}
+
+	for _, fn := range functions {
+		if fn.Kind != FunctionKind {
+			return nil, fmt.Errorf("unsupported Clusterfile pipeline stanza kind %q, want %q", fn.Kind, FunctionKind)
+		}
+
+		values, err := mergedValues(fn)
+		if err != nil {
+			return nil, err
+		}
+		rl.FunctionConfig = map[string]interface{}{
+			"values": values,
+		}
+
+		in, err := yaml.Marshal(rl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ResourceList for %s: %v", fn.Spec.Image, err)
+		}
+
+		var out []byte
+		if fn.Spec.Image == BuiltinReplacementTransformer {
+			out, err = runReplacementTransformer(in)
+		} else {
+			if runner == nil {
+				return nil, fmt.Errorf("no container runtime available to run ClusterfileFunction %s", fn.Spec.Image)
+			}
+			out, err = runner.Run(fn.Spec.Image, in)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ClusterfileFunction %s failed: %v", fn.Spec.Image, err)
+		}
+
+		var outRL ResourceList
+		if err := yaml.Unmarshal(out, &outRL); err != nil {
+			return nil, fmt.Errorf("failed to parse ResourceList returned by %s: %v", fn.Spec.Image, err)
+		}
+		if len(outRL.Items) != 1 {
+			return nil, fmt.Errorf("ClusterfileFunction %s returned %d items, want exactly 1", fn.Spec.Image, len(outRL.Items))
+		}
+
+		rl.Items = outRL.Items
+	}
+
+	return yaml.Marshal(rl.Items[0])
+}
+
+// mergedValues combines fn.Spec.Values with whatever fn.Spec.ValuesFrom
+// points at, with inline Values taking precedence on key collisions.
+func mergedValues(fn ClusterfileFunction) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	if fn.Spec.ValuesFrom != "" {
+		data, err := ioutil.ReadFile(fn.Spec.ValuesFrom)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read valuesFrom %s for %s: %v", fn.Spec.ValuesFrom, fn.Spec.Image, err)
+		}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse valuesFrom %s for %s: %v", fn.Spec.ValuesFrom, fn.Spec.Image, err)
+		}
+	}
+
+	for k, v := range fn.Spec.Values {
+		values[k] = v
+	}
+
+	return values, nil
+}
@@ -0,0 +1,75 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krm
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRunNoFunctionsIsNoOp(t *testing.T) {
+	in := []byte("apiVersion: sealer.io/v2\nkind: Cluster\n")
+	out, err := Run(in, nil, nil)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if string(out) != string(in) {
+		t.Errorf("Run with no functions = %q, want %q unchanged", out, in)
+	}
+}
+
+func TestRunBuiltinReplacementTransformer(t *testing.T) {
+	cluster := []byte("spec:\n  image: old:v1\n")
+
+	functions := []ClusterfileFunction{
+		{Kind: FunctionKind},
+	}
+	functions[0].Spec.Image = BuiltinReplacementTransformer
+	functions[0].Spec.Values = map[string]interface{}{"spec.image": "new:v2"}
+
+	out, err := Run(cluster, functions, nil)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatalf("failed to parse Run output: %v", err)
+	}
+	spec := asStringKeyedMap(got["spec"])
+	if spec["image"] != "new:v2" {
+		t.Errorf("spec.image = %v, want new:v2", spec["image"])
+	}
+}
+
+func TestRunUnsupportedKind(t *testing.T) {
+	cluster := []byte("spec:\n  image: old:v1\n")
+	functions := []ClusterfileFunction{{Kind: "NotAClusterfileFunction"}}
+
+	if _, err := Run(cluster, functions, nil); err == nil {
+		t.Fatal("Run with an unsupported function kind should error")
+	}
+}
+
+func TestRunExternalImageWithoutRunnerErrors(t *testing.T) {
+	cluster := []byte("spec:\n  image: old:v1\n")
+	functions := []ClusterfileFunction{{Kind: FunctionKind}}
+	functions[0].Spec.Image = "ghcr.io/example/transformer:v1"
+
+	if _, err := Run(cluster, functions, nil); err == nil {
+		t.Fatal("Run with an external image and a nil ContainerRunner should error")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krm
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// runReplacementTransformer implements BuiltinReplacementTransformer:
+// field-path substitution from a values map, so the common case of
+// templating a Clusterfile doesn't need an external image. A value entry
+// of the form "spec.image: mycompany/myimage:v1" replaces that dotted
+// path of map fields in the single resource item with the given scalar.
+func runReplacementTransformer(in []byte) ([]byte, error) {
+	var rl ResourceList
+	if err := yaml.Unmarshal(in, &rl); err != nil {
+		return nil, fmt.Errorf("failed to parse ResourceList: %v", err)
+	}
+	if len(rl.Items) != 1 {
+		return nil, fmt.Errorf("replacement-transformer expects exactly 1 item, got %d", len(rl.Items))
+	}
+
+	values := asStringKeyedMap(rl.FunctionConfig["values"])
+	item := rl.Items[0]
+	for path, value := range values {
+		if err := setFieldPath(item, strings.Split(path, "."), value); err != nil {
+			return nil, fmt.Errorf("replacement-transformer: %v", err)
+		}
+	}
+
+	return yaml.Marshal(rl)
+}
+
+// setFieldPath walks a dotted field path of map fields and overwrites the
+// leaf with value, mutating the map in place. Intermediate maps are
+// type-switched rather than copied: gopkg.in/yaml.v2 decodes the
+// top-level resource item as map[string]interface{} but any nested
+// mapping under it as map[interface{}]interface{}, and a copy would lose
+// the link back to its parent, silently dropping the write.
+func setFieldPath(node interface{}, path []string, value interface{}) error {
+	if len(path) == 0 {
+		return fmt.Errorf("empty field path")
+	}
+	key := path[0]
+
+	switch m := node.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			m[key] = value
+			return nil
+		}
+		child, ok := m[key]
+		if !ok {
+			return fmt.Errorf("field path %q not found", strings.Join(path, "."))
+		}
+		return setFieldPath(child, path[1:], value)
+	case map[interface{}]interface{}:
+		if len(path) == 1 {
+			m[key] = value
+			return nil
+		}
+		child, ok := m[key]
+		if !ok {
+			return fmt.Errorf("field path %q not found", strings.Join(path, "."))
+		}
+		return setFieldPath(child, path[1:], value)
+	default:
+		return fmt.Errorf("cannot walk into %T at %q", node, key)
+	}
+}
+
+// asStringKeyedMap normalizes the two shapes gopkg.in/yaml.v2 produces for
+// a read-only mapping lookup: a typed map[string]interface{}, or a bare
+// interface{} field decoded as map[interface{}]interface{}. Returns nil if
+// node is neither.
+func asStringKeyedMap(node interface{}) map[string]interface{} {
+	switch m := node.(type) {
+	case map[string]interface{}:
+		return m
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil
+			}
+			out[key] = v
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krm
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSetFieldPath(t *testing.T) {
+	item := map[string]interface{}{
+		"spec": map[interface{}]interface{}{
+			"image": "old:v1",
+		},
+	}
+
+	if err := setFieldPath(item, []string{"spec", "image"}, "new:v2"); err != nil {
+		t.Fatalf("setFieldPath returned error: %v", err)
+	}
+
+	spec := item["spec"].(map[interface{}]interface{})
+	if spec["image"] != "new:v2" {
+		t.Errorf("spec.image = %v, want new:v2", spec["image"])
+	}
+}
+
+func TestSetFieldPathUnknownField(t *testing.T) {
+	item := map[string]interface{}{"spec": map[string]interface{}{}}
+	if err := setFieldPath(item, []string{"spec", "missing", "leaf"}, "x"); err == nil {
+		t.Fatal("setFieldPath with an unknown intermediate field should error")
+	}
+}
+
+func TestAsStringKeyedMap(t *testing.T) {
+	fromTyped := asStringKeyedMap(map[string]interface{}{"a": 1})
+	if fromTyped["a"] != 1 {
+		t.Errorf("asStringKeyedMap(map[string]interface{}) = %v", fromTyped)
+	}
+
+	fromUntyped := asStringKeyedMap(map[interface{}]interface{}{"b": 2})
+	if fromUntyped["b"] != 2 {
+		t.Errorf("asStringKeyedMap(map[interface{}]interface{}) = %v", fromUntyped)
+	}
+
+	if asStringKeyedMap("not a map") != nil {
+		t.Error("asStringKeyedMap(non-map) should return nil")
+	}
+}
+
+func TestRunReplacementTransformer(t *testing.T) {
+	rl := ResourceList{
+		APIVersion: "config.kubernetes.io/v1",
+		Kind:       "ResourceList",
+		Items: []map[string]interface{}{
+			{"spec": map[string]interface{}{"image": "old:v1"}},
+		},
+		FunctionConfig: map[string]interface{}{
+			"values": map[string]interface{}{"spec.image": "new:v2"},
+		},
+	}
+
+	in, err := yaml.Marshal(rl)
+	if err != nil {
+		t.Fatalf("failed to marshal test ResourceList: %v", err)
+	}
+
+	out, err := runReplacementTransformer(in)
+	if err != nil {
+		t.Fatalf("runReplacementTransformer returned error: %v", err)
+	}
+
+	var outRL ResourceList
+	if err := yaml.Unmarshal(out, &outRL); err != nil {
+		t.Fatalf("failed to parse output ResourceList: %v", err)
+	}
+	if len(outRL.Items) != 1 {
+		t.Fatalf("output has %d items, want 1", len(outRL.Items))
+	}
+
+	spec := asStringKeyedMap(outRL.Items[0]["spec"])
+	if spec["image"] != "new:v2" {
+		t.Errorf("spec.image = %v, want new:v2", spec["image"])
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package krm
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// DockerRunner is a ContainerRunner that shells out to the docker CLI,
+// the same way `kind load docker-image` drives docker rather than linking
+// against a container runtime library.
+type DockerRunner struct {
+	// DockerPath is the resolved path to the docker binary.
+	DockerPath string
+}
+
+// NewContainerRunner returns a DockerRunner backed by the docker binary on
+// PATH, or nil if docker isn't installed. A nil ContainerRunner is what
+// Run already treats as "no container runtime available", so callers can
+// wire this in unconditionally and only users who actually declare an
+// external `kind: ClusterfileFunction` image need docker installed.
+func NewContainerRunner() ContainerRunner {
+	path, err := exec.LookPath("docker")
+	if err != nil {
+		return nil
+	}
+	return &DockerRunner{DockerPath: path}
+}
+
+// Run streams resourceList into `docker run --rm -i image` and returns
+// whatever the container wrote to stdout.
+func (r *DockerRunner) Run(image string, resourceList []byte) ([]byte, error) {
+	cmd := exec.Command(r.DockerPath, "run", "--rm", "-i", image)
+	cmd.Stdin = bytes.NewReader(resourceList)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("docker run %s: %v: %s", image, err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,32 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package yamlutil holds small YAML helpers shared across packages that
+// each parse their own multi-document Clusterfile stanzas (pkg/image/policy,
+// pkg/krm), so the splitting logic isn't forked between them.
+package yamlutil
+
+import "bytes"
+
+// SplitDocuments splits a multi-document YAML file on "---" document
+// separator lines.
+func SplitDocuments(data []byte) [][]byte {
+	var docs [][]byte
+	for _, raw := range bytes.Split(data, []byte("\n---")) {
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 {
+			docs = append(docs, trimmed)
+		}
+	}
+	return docs
+}